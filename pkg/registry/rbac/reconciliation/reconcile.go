@@ -0,0 +1,252 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package reconciliation implements three-way merge reconciliation of the RBAC role and
+// binding types (ClusterRole, Role, ClusterRoleBinding, RoleBinding) against persisted
+// bootstrap policy. The helpers in this file hold the logic shared by all four flows so
+// each type-specific file only has to wire up the Get/Create/Update calls.
+package reconciliation
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/kubernetes/pkg/apis/rbac"
+	"k8s.io/kubernetes/pkg/registry/rbac/validation"
+)
+
+type ReconcileOperation string
+
+var (
+	ReconcileCreate   ReconcileOperation = "create"
+	ReconcileUpdate   ReconcileOperation = "update"
+	ReconcileRecreate ReconcileOperation = "recreate"
+	ReconcileNone     ReconcileOperation = "none"
+)
+
+// merge combines the given maps with the later annotations having higher precedence
+func merge(maps ...map[string]string) map[string]string {
+	var output map[string]string = nil
+	for _, m := range maps {
+		if m != nil && output == nil {
+			output = map[string]string{}
+		}
+		for k, v := range m {
+			output[k] = v
+		}
+	}
+	return output
+}
+
+// isProtected returns true if the object opted out of reconciliation via the
+// rbac.authorization.k8s.io/autoupdate annotation.
+func isProtected(annotations map[string]string) bool {
+	return annotations[rbac.AutoUpdateAnnotationKey] == "false"
+}
+
+// mergeAnnotationsAndLabels merges expected annotations/labels onto the existing ones,
+// reporting whether either map changed as a result.
+func mergeAnnotationsAndLabels(existingAnnotations, expectedAnnotations, existingLabels, expectedLabels map[string]string) (annotations, labels map[string]string, changed bool) {
+	annotations = merge(expectedAnnotations, existingAnnotations)
+	if !reflect.DeepEqual(annotations, existingAnnotations) {
+		changed = true
+	}
+	labels = merge(expectedLabels, existingLabels)
+	if !reflect.DeepEqual(labels, existingLabels) {
+		changed = true
+	}
+	return annotations, labels, changed
+}
+
+// reconcilePolicyRules applies the union/stomp policy-rule semantics shared by ClusterRole
+// and Role reconciliation: in the union case (removeExtraPermissions == false) any rule
+// expected but missing from existing is appended; in the stomp case the existing rules are
+// replaced outright with the expected ones whenever they diverge.
+//
+// Rules are normalized before diffing and before deciding whether an update is required, so
+// cosmetic differences in verb/resource/apiGroup ordering, casing, or duplication don't churn
+// resourceVersion with a no-op update.
+func reconcilePolicyRules(existingRules, expectedRules []rbac.PolicyRule, removeExtraPermissions bool) (rules []rbac.PolicyRule, missingRules, extraRules []rbac.PolicyRule, changed bool) {
+	normalizedExisting := normalizePolicyRules(existingRules)
+	normalizedExpected := normalizePolicyRules(expectedRules)
+
+	// missingRules/extraRules are reported - and, for missingRules, persisted - in the rule
+	// structure the caller authored; normalization is only used to decide coverage, never
+	// surfaced to the caller or written to the object.
+	missingRules = uncoveredOriginalRules(expectedRules, normalizedExisting)
+	extraRules = uncoveredOriginalRules(existingRules, normalizedExpected)
+
+	rules = existingRules
+	switch {
+	case !removeExtraPermissions && len(missingRules) > 0:
+		// add missing rules in the union case
+		rules = append(append([]rbac.PolicyRule{}, existingRules...), missingRules...)
+
+	case removeExtraPermissions && (len(missingRules) > 0 || len(extraRules) > 0):
+		// stomp to expected rules in the non-union case
+		rules = expectedRules
+	}
+
+	changed = !apiequality.Semantic.DeepEqual(normalizePolicyRules(rules), normalizedExisting)
+
+	return rules, missingRules, extraRules, changed
+}
+
+// uncoveredOriginalRules returns the subset of candidates (in their original, as-authored form)
+// that normalizedOwner does not cover, comparing on normalized forms so cosmetic differences in
+// verb/resource/apiGroup ordering, casing, or duplication don't count as a difference.
+func uncoveredOriginalRules(candidates, normalizedOwner []rbac.PolicyRule) []rbac.PolicyRule {
+	var uncovered []rbac.PolicyRule
+	for _, rule := range candidates {
+		if !ruleCoveredBy(normalizedOwner, rule) {
+			uncovered = append(uncovered, rule)
+		}
+	}
+	return uncovered
+}
+
+// ruleCoveredBy reports whether rule is already covered by the (already normalized) owner rule
+// set, comparing on the normalized form of rule so cosmetic differences don't cause a rule to
+// be considered missing.
+func ruleCoveredBy(normalizedOwner []rbac.PolicyRule, rule rbac.PolicyRule) bool {
+	_, uncovered := validation.Covers(normalizedOwner, normalizePolicyRules([]rbac.PolicyRule{rule}))
+	return len(uncovered) == 0
+}
+
+// normalizePolicyRules returns a copy of rules with Verbs, Resources and APIGroups lowercased,
+// deduped and deterministically sorted, and rules that only differ in their Verbs coalesced
+// into one rule with their verbs unioned (see coalesceEquivalentRules). This lets two rule sets that grant
+// the same effective permissions, but were authored with different ordering or casing, compare
+// equal.
+func normalizePolicyRules(rules []rbac.PolicyRule) []rbac.PolicyRule {
+	normalized := make([]rbac.PolicyRule, 0, len(rules))
+	for _, rule := range rules {
+		normalized = append(normalized, normalizePolicyRule(rule))
+	}
+	return coalesceEquivalentRules(normalized)
+}
+
+func normalizePolicyRule(rule rbac.PolicyRule) rbac.PolicyRule {
+	rule.Verbs = lowercaseSortedSet(rule.Verbs)
+	rule.APIGroups = lowercaseSortedSet(rule.APIGroups)
+	rule.Resources = lowercaseSortedSet(rule.Resources)
+	rule.ResourceNames = sortedSet(rule.ResourceNames)
+	rule.NonResourceURLs = sortedSet(rule.NonResourceURLs)
+	return rule
+}
+
+func lowercaseSortedSet(values []string) []string {
+	set := sets.NewString()
+	for _, v := range values {
+		set.Insert(strings.ToLower(v))
+	}
+	return set.List()
+}
+
+func sortedSet(values []string) []string {
+	return sets.NewString(values...).List()
+}
+
+// coalesceEquivalentRules merges rules whose non-verb fields (APIGroups, Resources,
+// ResourceNames, NonResourceURLs) are identical after normalization into a single rule with
+// their Verbs unioned, so e.g. {verbs: [get]} and {verbs: [list]} against the same resource
+// collapse into one {verbs: [get, list]} rule before comparison.
+func coalesceEquivalentRules(rules []rbac.PolicyRule) []rbac.PolicyRule {
+	order := []string{}
+	byKey := map[string]*rbac.PolicyRule{}
+	for _, rule := range rules {
+		key := fmt.Sprintf("%v|%v|%v|%v", rule.APIGroups, rule.Resources, rule.ResourceNames, rule.NonResourceURLs)
+		if existing, ok := byKey[key]; ok {
+			existing.Verbs = lowercaseSortedSet(append(existing.Verbs, rule.Verbs...))
+			continue
+		}
+		r := rule
+		order = append(order, key)
+		byKey[key] = &r
+	}
+
+	out := make([]rbac.PolicyRule, 0, len(order))
+	for _, key := range order {
+		out = append(out, *byKey[key])
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return fmt.Sprintf("%#v", out[i]) < fmt.Sprintf("%#v", out[j])
+	})
+	return out
+}
+
+// subjectsEqual returns true if the two subjects refer to the same principal.
+func subjectsEqual(a, b rbac.Subject) bool {
+	return a.Kind == b.Kind && a.APIGroup == b.APIGroup && a.Name == b.Name && a.Namespace == b.Namespace
+}
+
+func containsSubject(subjects []rbac.Subject, subject rbac.Subject) bool {
+	for _, s := range subjects {
+		if subjectsEqual(s, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffSubjects computes the subjects expected but missing from existing, and the subjects
+// present in existing but not expected. Subjects matching excluded are never reported as
+// extra, so operators can preserve out-of-band-added subjects across reconciliation.
+func diffSubjects(existingSubjects, expectedSubjects, excludedSubjects []rbac.Subject) (missingSubjects, extraSubjects []rbac.Subject) {
+	for _, expected := range expectedSubjects {
+		if !containsSubject(existingSubjects, expected) {
+			missingSubjects = append(missingSubjects, expected)
+		}
+	}
+	for _, existing := range existingSubjects {
+		if containsSubject(expectedSubjects, existing) || containsSubject(excludedSubjects, existing) {
+			continue
+		}
+		extraSubjects = append(extraSubjects, existing)
+	}
+	return missingSubjects, extraSubjects
+}
+
+// reconcileSubjects applies the same union/stomp semantics as reconcilePolicyRules, to
+// subject lists, while always preserving any existing subject that matches excludedSubjects.
+func reconcileSubjects(existingSubjects, expectedSubjects, excludedSubjects []rbac.Subject, removeExtraSubjects bool) (subjects []rbac.Subject, missingSubjects, extraSubjects []rbac.Subject, changed bool) {
+	missingSubjects, extraSubjects = diffSubjects(existingSubjects, expectedSubjects, excludedSubjects)
+
+	switch {
+	case !removeExtraSubjects && len(missingSubjects) > 0:
+		subjects = append(append([]rbac.Subject{}, existingSubjects...), missingSubjects...)
+		changed = true
+
+	case removeExtraSubjects && (len(missingSubjects) > 0 || len(extraSubjects) > 0):
+		subjects = append([]rbac.Subject{}, expectedSubjects...)
+		for _, existing := range existingSubjects {
+			if containsSubject(excludedSubjects, existing) && !containsSubject(subjects, existing) {
+				// Excluded subjects are preserved verbatim even when stomping to expected.
+				subjects = append(subjects, existing)
+			}
+		}
+		changed = true
+
+	default:
+		subjects = existingSubjects
+	}
+
+	return subjects, missingSubjects, extraSubjects, changed
+}
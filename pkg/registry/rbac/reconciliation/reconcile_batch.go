@@ -0,0 +1,337 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciliation
+
+import (
+	"fmt"
+	"sync"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/kubernetes/pkg/apis/rbac"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/typed/rbac/internalversion"
+)
+
+// BootstrapPolicy is the set of expected roles and bindings a BatchReconciler reconciles
+// against live cluster state in a single pass.
+type BootstrapPolicy struct {
+	ClusterRoles        []*rbac.ClusterRole
+	Roles               []*rbac.Role
+	ClusterRoleBindings []*rbac.ClusterRoleBinding
+	RoleBindings        []*rbac.RoleBinding
+}
+
+// BatchReconcilerClient supplies the per-kind clients a BatchReconciler needs. RoleClient and
+// RoleBindingClient are scoped per-namespace, mirroring how callers obtain namespaced clients
+// from the generated clientset (e.g. client.Rbac().Roles(namespace)).
+type BatchReconcilerClient struct {
+	ClusterRoleClient        internalversion.ClusterRoleInterface
+	ClusterRoleBindingClient internalversion.ClusterRoleBindingInterface
+	RoleClient               func(namespace string) internalversion.RoleInterface
+	RoleBindingClient        func(namespace string) internalversion.RoleBindingInterface
+}
+
+// BatchReconcileItemResult is the outcome of reconciling a single object as part of a
+// BatchReconciler run.
+type BatchReconcileItemResult struct {
+	// Kind is one of "ClusterRole", "Role", "ClusterRoleBinding", "RoleBinding"
+	Kind      string
+	Namespace string
+	Name      string
+
+	Operation ReconcileOperation
+	Protected bool
+
+	MissingRules []rbac.PolicyRule
+	ExtraRules   []rbac.PolicyRule
+
+	MissingSubjects []rbac.Subject
+	ExtraSubjects   []rbac.Subject
+
+	// Error is set if reconciling this object failed. A failed item still contributes its
+	// partial Operation/Protected/diff information gathered before the failure, if any.
+	Error error
+}
+
+// String renders a unified-diff-style one-line-per-change summary suitable for DryRun output.
+func (r BatchReconcileItemResult) String() string {
+	id := r.Name
+	if r.Namespace != "" {
+		id = r.Namespace + "/" + r.Name
+	}
+	if r.Error != nil {
+		return fmt.Sprintf("%s %s: error: %v", r.Kind, id, r.Error)
+	}
+	if r.Operation == ReconcileNone {
+		return fmt.Sprintf("%s %s: unchanged", r.Kind, id)
+	}
+	header := fmt.Sprintf("%s %s: %s", r.Kind, id, r.Operation)
+	if r.Protected {
+		return header + " (skipped, protected by " + rbac.AutoUpdateAnnotationKey + "=false)"
+	}
+	lines := []string{header}
+	for _, rule := range r.MissingRules {
+		lines = append(lines, fmt.Sprintf("  + %#v", rule))
+	}
+	for _, rule := range r.ExtraRules {
+		lines = append(lines, fmt.Sprintf("  - %#v", rule))
+	}
+	for _, subject := range r.MissingSubjects {
+		lines = append(lines, fmt.Sprintf("  + %s", formatSubject(subject)))
+	}
+	for _, subject := range r.ExtraSubjects {
+		lines = append(lines, fmt.Sprintf("  - %s", formatSubject(subject)))
+	}
+	out := lines[0]
+	for _, l := range lines[1:] {
+		out += "\n" + l
+	}
+	return out
+}
+
+func formatSubject(s rbac.Subject) string {
+	if s.Namespace != "" {
+		return fmt.Sprintf("%s %s/%s", s.Kind, s.Namespace, s.Name)
+	}
+	return fmt.Sprintf("%s %s", s.Kind, s.Name)
+}
+
+// BatchReconcileReport is the aggregated outcome of a BatchReconciler run.
+type BatchReconcileReport struct {
+	Items []BatchReconcileItemResult
+}
+
+// String renders the whole report as a DryRun-style summary, one object per block.
+func (rep *BatchReconcileReport) String() string {
+	out := ""
+	for i, item := range rep.Items {
+		if i > 0 {
+			out += "\n"
+		}
+		out += item.String()
+	}
+	return out
+}
+
+// BatchReconciler reconciles a whole bootstrap policy set - every expected ClusterRole, Role,
+// ClusterRoleBinding and RoleBinding - in one pass, instead of requiring callers to invoke
+// Run() once per object and stitch the results together themselves.
+type BatchReconciler struct {
+	Policy BootstrapPolicy
+	Client BatchReconcilerClient
+
+	// Confirm indicates writes should be performed. When false, results are returned as a dry-run.
+	Confirm bool
+	// RemoveExtraPermissions indicates reconciliation should remove extra permissions from existing roles
+	RemoveExtraPermissions bool
+	// RemoveExtraSubjects indicates reconciliation should remove extra subjects from existing bindings
+	RemoveExtraSubjects bool
+	// ExcludeSubjects lists subjects to preserve across binding reconciliation even when RemoveExtraSubjects is set
+	ExcludeSubjects []rbac.Subject
+
+	// RolesToReconcile restricts reconciliation to objects with a matching name. An empty set reconciles everything.
+	RolesToReconcile sets.String
+
+	// DryRun forces Confirm=false for every object in this run, regardless of the Confirm field,
+	// so the returned report can be printed as a preview without any risk of a write slipping through.
+	DryRun bool
+
+	// Concurrency bounds how many objects are reconciled at once. Values <= 1 reconcile serially.
+	Concurrency int
+
+	// ContinueOnError causes the batch to keep reconciling remaining objects after a failure,
+	// accumulating every error into a utilerrors.Aggregate instead of aborting on the first one.
+	ContinueOnError bool
+}
+
+// batchReconcileTask is a unit of work submitted to the worker pool; it closes over everything
+// needed to reconcile one object and append its BatchReconcileItemResult.
+type batchReconcileTask func() BatchReconcileItemResult
+
+// Run reconciles every object in the policy set, honoring Concurrency, RolesToReconcile, and
+// ContinueOnError. It returns the partial report gathered so far even when it returns an error.
+func (b *BatchReconciler) Run() (*BatchReconcileReport, error) {
+	tasks := b.buildTasks()
+
+	report := &BatchReconcileReport{Items: make([]BatchReconcileItemResult, len(tasks))}
+	errs := []error{}
+
+	concurrency := b.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		aborted bool
+		sem     = make(chan struct{}, concurrency)
+	)
+
+	for i, task := range tasks {
+		// Wait for a worker slot before checking for a failure, so a serial run stops right
+		// after the failing object instead of starting one more.
+		sem <- struct{}{}
+		mu.Lock()
+		stop := aborted && !b.ContinueOnError
+		mu.Unlock()
+		if stop {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, task batchReconcileTask) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result := task()
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Items[i] = result
+			if result.Error != nil {
+				errs = append(errs, result.Error)
+				aborted = true
+			}
+		}(i, task)
+	}
+
+	wg.Wait()
+
+	// Trim unset trailing entries if we stopped early without ContinueOnError.
+	trimmed := report.Items[:0]
+	for _, item := range report.Items {
+		if item.Kind == "" {
+			continue
+		}
+		trimmed = append(trimmed, item)
+	}
+	report.Items = trimmed
+
+	return report, utilerrors.NewAggregate(errs)
+}
+
+func (b *BatchReconciler) buildTasks() []batchReconcileTask {
+	tasks := []batchReconcileTask{}
+
+	confirm := b.Confirm && !b.DryRun
+
+	for _, role := range b.Policy.ClusterRoles {
+		if !b.shouldReconcile(role.Name) {
+			continue
+		}
+		role := role
+		tasks = append(tasks, func() BatchReconcileItemResult {
+			options := &ReconcileClusterRoleOptions{
+				Role:                   role,
+				Confirm:                confirm,
+				RemoveExtraPermissions: b.RemoveExtraPermissions,
+				Client:                 b.Client.ClusterRoleClient,
+			}
+			result, err := options.Run()
+			item := BatchReconcileItemResult{Kind: "ClusterRole", Name: role.Name, Error: err}
+			if result != nil {
+				item.Operation = result.Operation
+				item.Protected = result.Protected
+				item.MissingRules = result.MissingRules
+				item.ExtraRules = result.ExtraRules
+			}
+			return item
+		})
+	}
+
+	for _, role := range b.Policy.Roles {
+		if !b.shouldReconcile(role.Name) {
+			continue
+		}
+		role := role
+		tasks = append(tasks, func() BatchReconcileItemResult {
+			options := &ReconcileRoleOptions{
+				Role:                   role,
+				Confirm:                confirm,
+				RemoveExtraPermissions: b.RemoveExtraPermissions,
+				Client:                 b.Client.RoleClient(role.Namespace),
+			}
+			result, err := options.Run()
+			item := BatchReconcileItemResult{Kind: "Role", Namespace: role.Namespace, Name: role.Name, Error: err}
+			if result != nil {
+				item.Operation = result.Operation
+				item.Protected = result.Protected
+				item.MissingRules = result.MissingRules
+				item.ExtraRules = result.ExtraRules
+			}
+			return item
+		})
+	}
+
+	for _, binding := range b.Policy.ClusterRoleBindings {
+		if !b.shouldReconcile(binding.Name) {
+			continue
+		}
+		binding := binding
+		tasks = append(tasks, func() BatchReconcileItemResult {
+			options := &ReconcileClusterRoleBindingOptions{
+				RoleBinding:         binding,
+				Confirm:             confirm,
+				RemoveExtraSubjects: b.RemoveExtraSubjects,
+				ExcludeSubjects:     b.ExcludeSubjects,
+				Client:              b.Client.ClusterRoleBindingClient,
+			}
+			result, err := options.Run()
+			item := BatchReconcileItemResult{Kind: "ClusterRoleBinding", Name: binding.Name, Error: err}
+			if result != nil {
+				item.Operation = result.Operation
+				item.Protected = result.Protected
+				item.MissingSubjects = result.MissingSubjects
+				item.ExtraSubjects = result.ExtraSubjects
+			}
+			return item
+		})
+	}
+
+	for _, binding := range b.Policy.RoleBindings {
+		if !b.shouldReconcile(binding.Name) {
+			continue
+		}
+		binding := binding
+		tasks = append(tasks, func() BatchReconcileItemResult {
+			options := &ReconcileRoleBindingOptions{
+				RoleBinding:         binding,
+				Confirm:             confirm,
+				RemoveExtraSubjects: b.RemoveExtraSubjects,
+				ExcludeSubjects:     b.ExcludeSubjects,
+				Client:              b.Client.RoleBindingClient(binding.Namespace),
+			}
+			result, err := options.Run()
+			item := BatchReconcileItemResult{Kind: "RoleBinding", Namespace: binding.Namespace, Name: binding.Name, Error: err}
+			if result != nil {
+				item.Operation = result.Operation
+				item.Protected = result.Protected
+				item.MissingSubjects = result.MissingSubjects
+				item.ExtraSubjects = result.ExtraSubjects
+			}
+			return item
+		})
+	}
+
+	return tasks
+}
+
+func (b *BatchReconciler) shouldReconcile(name string) bool {
+	return b.RolesToReconcile == nil || b.RolesToReconcile.Len() == 0 || b.RolesToReconcile.Has(name)
+}
@@ -19,22 +19,13 @@ package reconciliation
 import (
 	"fmt"
 	"reflect"
+	"time"
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/apis/rbac"
 	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/typed/rbac/internalversion"
-	"k8s.io/kubernetes/pkg/registry/rbac/validation"
-)
-
-type ReconcileOperation string
-
-var (
-	ReconcileCreate   ReconcileOperation = "create"
-	ReconcileUpdate   ReconcileOperation = "update"
-	ReconcileRecreate ReconcileOperation = "recreate"
-	ReconcileNone     ReconcileOperation = "none"
 )
 
 type ReconcileClusterRoleOptions struct {
@@ -46,6 +37,9 @@ type ReconcileClusterRoleOptions struct {
 	RemoveExtraPermissions bool
 	// Client is used to look up existing roles, and create/update the role when Confirm=true
 	Client internalversion.ClusterRoleInterface
+	// Recorder, if set, is notified of the reconciliation outcome so callers can surface it as
+	// a Kubernetes Event.
+	Recorder Recorder
 }
 
 type ReconcileClusterRoleResult struct {
@@ -69,7 +63,30 @@ type ReconcileClusterRoleResult struct {
 }
 
 func (o *ReconcileClusterRoleOptions) Run() (*ReconcileClusterRoleResult, error) {
-	return o.run(0)
+	start := time.Now()
+	result, err := o.run(0)
+	if err != nil {
+		return result, err
+	}
+
+	if o.Confirm {
+		// Dry-run reconciles never perform the operation they compute, so don't count them as
+		// if they had.
+		observeReconcile(result.Operation, result.Protected, start)
+	}
+	if o.Recorder != nil {
+		switch {
+		case result.Protected:
+			o.Recorder.RoleProtected(o.Role.Name)
+		case len(result.MissingRules) > 0 || len(result.ExtraRules) > 0:
+			// Fire even when Operation == ReconcileNone: in the default union mode, detected
+			// ExtraRules are reported but never removed, so no write happens even though drift
+			// was found.
+			o.Recorder.RoleReconciled(o.Role.Name, result.Operation, result.MissingRules, result.ExtraRules)
+		}
+	}
+
+	return result, nil
 }
 
 func (o *ReconcileClusterRoleOptions) run(attempts int) (*ReconcileClusterRoleResult, error) {
@@ -147,7 +164,7 @@ func (o *ReconcileClusterRoleOptions) run(attempts int) (*ReconcileClusterRoleRe
 func computeReconciledRole(existing, expected *rbac.ClusterRole, removeExtraPermissions bool) (*ReconcileClusterRoleResult, error) {
 	result := &ReconcileClusterRoleResult{Operation: ReconcileNone}
 
-	result.Protected = (existing.Annotations[rbac.AutoUpdateAnnotationKey] == "false")
+	result.Protected = isProtected(existing.Annotations)
 
 	// Start with a copy of the existing object
 	changedObj, err := api.Scheme.DeepCopy(existing)
@@ -157,44 +174,43 @@ func computeReconciledRole(existing, expected *rbac.ClusterRole, removeExtraPerm
 	result.Role = changedObj.(*rbac.ClusterRole)
 
 	// Merge expected annotations and labels
-	result.Role.Annotations = merge(expected.Annotations, result.Role.Annotations)
-	if !reflect.DeepEqual(result.Role.Annotations, existing.Annotations) {
-		result.Operation = ReconcileUpdate
-	}
-	result.Role.Labels = merge(expected.Labels, result.Role.Labels)
-	if !reflect.DeepEqual(result.Role.Labels, existing.Labels) {
+	annotations, labels, metaChanged := mergeAnnotationsAndLabels(existing.Annotations, expected.Annotations, existing.Labels, expected.Labels)
+	result.Role.Annotations = annotations
+	result.Role.Labels = labels
+	if metaChanged {
 		result.Operation = ReconcileUpdate
 	}
 
-	// Compute extra and missing rules
-	_, result.ExtraRules = validation.Covers(expected.Rules, existing.Rules)
-	_, result.MissingRules = validation.Covers(existing.Rules, expected.Rules)
-
-	switch {
-	case !removeExtraPermissions && len(result.MissingRules) > 0:
-		// add missing rules in the union case
-		result.Role.Rules = append(result.Role.Rules, result.MissingRules...)
-		result.Operation = ReconcileUpdate
+	// Aggregated roles have their Rules managed by the aggregation controller, which selects
+	// and unions the rules of the ClusterRoles matched by AggregationRule.ClusterRoleSelectors.
+	// If either the existing or expected role is aggregated, reconcile the AggregationRule
+	// itself and leave Rules alone so we don't fight the aggregation controller or treat its
+	// injected rules as drift. Note that on the aggregated->non-aggregated transition this
+	// means expected.Rules only takes effect on the next reconcile pass, once AggregationRule
+	// is already nil.
+	if existing.AggregationRule != nil || expected.AggregationRule != nil {
+		if expected.AggregationRule == nil {
+			result.Role.AggregationRule = nil
+		} else {
+			copied, err := api.Scheme.DeepCopy(expected.AggregationRule)
+			if err != nil {
+				return nil, err
+			}
+			result.Role.AggregationRule = copied.(*rbac.AggregationRule)
+		}
+		if !reflect.DeepEqual(result.Role.AggregationRule, existing.AggregationRule) {
+			result.Operation = ReconcileUpdate
+		}
+		return result, nil
+	}
 
-	case removeExtraPermissions && (len(result.MissingRules) > 0 || len(result.ExtraRules) > 0):
-		// stomp to expected rules in the non-union case
-		result.Role.Rules = expected.Rules
+	rules, missingRules, extraRules, rulesChanged := reconcilePolicyRules(existing.Rules, expected.Rules, removeExtraPermissions)
+	result.Role.Rules = rules
+	result.MissingRules = missingRules
+	result.ExtraRules = extraRules
+	if rulesChanged {
 		result.Operation = ReconcileUpdate
 	}
 
 	return result, nil
 }
-
-// merge combines the given maps with the later annotations having higher precedence
-func merge(maps ...map[string]string) map[string]string {
-	var output map[string]string = nil
-	for _, m := range maps {
-		if m != nil && output == nil {
-			output = map[string]string{}
-		}
-		for k, v := range m {
-			output[k] = v
-		}
-	}
-	return output
-}
@@ -0,0 +1,124 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciliation
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/apis/rbac"
+)
+
+func TestComputeReconciledRoleAggregation(t *testing.T) {
+	tests := map[string]struct {
+		existing *rbac.ClusterRole
+		expected *rbac.ClusterRole
+
+		wantOperation       ReconcileOperation
+		wantAggregationRule *rbac.AggregationRule
+		wantRules           []rbac.PolicyRule
+	}{
+		"stays aggregated, selectors updated": {
+			existing: &rbac.ClusterRole{
+				AggregationRule: &rbac.AggregationRule{
+					ClusterRoleSelectors: []metav1.LabelSelector{{MatchLabels: map[string]string{"old": "true"}}},
+				},
+				Rules: []rbac.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+			},
+			expected: &rbac.ClusterRole{
+				AggregationRule: &rbac.AggregationRule{
+					ClusterRoleSelectors: []metav1.LabelSelector{{MatchLabels: map[string]string{"new": "true"}}},
+				},
+			},
+			wantOperation: ReconcileUpdate,
+			wantAggregationRule: &rbac.AggregationRule{
+				ClusterRoleSelectors: []metav1.LabelSelector{{MatchLabels: map[string]string{"new": "true"}}},
+			},
+			// Rules injected by the aggregation controller must be left untouched.
+			wantRules: []rbac.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+		},
+		"stays aggregated, no change": {
+			existing: &rbac.ClusterRole{
+				AggregationRule: &rbac.AggregationRule{
+					ClusterRoleSelectors: []metav1.LabelSelector{{MatchLabels: map[string]string{"k": "v"}}},
+				},
+			},
+			expected: &rbac.ClusterRole{
+				AggregationRule: &rbac.AggregationRule{
+					ClusterRoleSelectors: []metav1.LabelSelector{{MatchLabels: map[string]string{"k": "v"}}},
+				},
+			},
+			wantOperation: ReconcileNone,
+			wantAggregationRule: &rbac.AggregationRule{
+				ClusterRoleSelectors: []metav1.LabelSelector{{MatchLabels: map[string]string{"k": "v"}}},
+			},
+		},
+		"aggregated to non-aggregated": {
+			existing: &rbac.ClusterRole{
+				AggregationRule: &rbac.AggregationRule{
+					ClusterRoleSelectors: []metav1.LabelSelector{{MatchLabels: map[string]string{"k": "v"}}},
+				},
+				Rules: []rbac.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+			},
+			expected: &rbac.ClusterRole{
+				Rules: []rbac.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+			},
+			wantOperation:       ReconcileUpdate,
+			wantAggregationRule: nil,
+			// Even when dropping aggregation, rule diffing is skipped for this transition; the
+			// aggregation controller's last-written rules are left as-is until the next reconcile.
+			wantRules: []rbac.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+		},
+		"non-aggregated to aggregated": {
+			existing: &rbac.ClusterRole{
+				Rules: []rbac.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+			},
+			expected: &rbac.ClusterRole{
+				AggregationRule: &rbac.AggregationRule{
+					ClusterRoleSelectors: []metav1.LabelSelector{{MatchLabels: map[string]string{"k": "v"}}},
+				},
+			},
+			wantOperation: ReconcileUpdate,
+			wantAggregationRule: &rbac.AggregationRule{
+				ClusterRoleSelectors: []metav1.LabelSelector{{MatchLabels: map[string]string{"k": "v"}}},
+			},
+			wantRules: []rbac.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			result, err := computeReconciledRole(tc.existing, tc.expected, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Operation != tc.wantOperation {
+				t.Errorf("got operation %v, want %v", result.Operation, tc.wantOperation)
+			}
+			if !reflect.DeepEqual(result.Role.AggregationRule, tc.wantAggregationRule) {
+				t.Errorf("got aggregation rule %#v, want %#v", result.Role.AggregationRule, tc.wantAggregationRule)
+			}
+			if !reflect.DeepEqual(result.Role.Rules, tc.wantRules) {
+				t.Errorf("got rules %#v, want %#v", result.Role.Rules, tc.wantRules)
+			}
+			if len(result.MissingRules) != 0 || len(result.ExtraRules) != 0 {
+				t.Errorf("expected no rule diffing for aggregated roles, got missing=%#v extra=%#v", result.MissingRules, result.ExtraRules)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciliation
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/rbac"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/typed/rbac/internalversion"
+)
+
+type ReconcileClusterRoleBindingOptions struct {
+	// RoleBinding is the expected rolebinding that will be reconciled
+	RoleBinding *rbac.ClusterRoleBinding
+	// Confirm indicates writes should be performed. When false, results are returned as a dry-run.
+	Confirm bool
+	// RemoveExtraSubjects indicates reconciliation should remove extra subjects from an existing rolebinding
+	RemoveExtraSubjects bool
+	// ExcludeSubjects is a list of subjects that should be ignored when computing extra
+	// subjects to remove, so operators can hand-add subjects out of band and keep them across
+	// reconciliation even when RemoveExtraSubjects is set.
+	ExcludeSubjects []rbac.Subject
+	// Client is used to look up existing rolebindings, and create/update/delete the rolebinding when Confirm=true
+	Client internalversion.ClusterRoleBindingInterface
+}
+
+type ReconcileClusterRoleBindingResult struct {
+	// RoleBinding is the reconciled rolebinding from the reconciliation operation.
+	// If the reconcile was performed as a dry-run, or the existing rolebinding was protected, the reconciled rolebinding is not persisted.
+	RoleBinding *rbac.ClusterRoleBinding
+
+	// MissingSubjects contains expected subjects that were missing from the currently persisted rolebinding
+	MissingSubjects []rbac.Subject
+	// ExtraSubjects contains extra subjects the currently persisted rolebinding had
+	ExtraSubjects []rbac.Subject
+
+	// Operation is the API operation required to reconcile.
+	// If no reconciliation was needed, it is set to ReconcileNone.
+	// If the RoleRef differs between the existing and expected rolebinding, it is set to
+	// ReconcileRecreate, since RoleRef is immutable and the rolebinding must be deleted and recreated.
+	// If options.Confirm == false, the reconcile was in dry-run mode, so the operation was not performed.
+	// If result.Protected == true, the rolebinding opted out of reconciliation, so the operation was not performed.
+	// Otherwise, the operation was performed.
+	Operation ReconcileOperation
+	// Protected indicates an existing rolebinding prevented reconciliation
+	Protected bool
+}
+
+func (o *ReconcileClusterRoleBindingOptions) Run() (*ReconcileClusterRoleBindingResult, error) {
+	start := time.Now()
+	result, err := o.run(0)
+	if err != nil {
+		return result, err
+	}
+	if o.Confirm {
+		// Dry-run reconciles never perform the operation they compute, so don't count them as
+		// if they had.
+		observeReconcile(result.Operation, result.Protected, start)
+	}
+	return result, nil
+}
+
+func (o *ReconcileClusterRoleBindingOptions) run(attempts int) (*ReconcileClusterRoleBindingResult, error) {
+	// This keeps us from retrying forever if a binding keeps appearing and disappearing as we reconcile.
+	// Conflict errors on update are handled at a higher level.
+	if attempts > 2 {
+		return nil, fmt.Errorf("exceeded maximum attempts")
+	}
+
+	var result *ReconcileClusterRoleBindingResult
+
+	existing, err := o.Client.Get(o.RoleBinding.Name, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		result = &ReconcileClusterRoleBindingResult{
+			RoleBinding:     o.RoleBinding,
+			MissingSubjects: o.RoleBinding.Subjects,
+			Operation:       ReconcileCreate,
+		}
+
+	case err != nil:
+		return nil, err
+
+	default:
+		result, err = computeReconciledClusterRoleBinding(existing, o.RoleBinding, o.RemoveExtraSubjects, o.ExcludeSubjects)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// If reconcile-protected, short-circuit
+	if result.Protected {
+		return result, nil
+	}
+	// If we're in dry-run mode, short-circuit
+	if !o.Confirm {
+		return result, nil
+	}
+
+	switch result.Operation {
+	case ReconcileCreate:
+		created, err := o.Client.Create(result.RoleBinding)
+		// If created since we started this reconcile, re-run
+		if errors.IsAlreadyExists(err) {
+			return o.run(attempts + 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.RoleBinding = created
+
+	case ReconcileUpdate:
+		updated, err := o.Client.Update(result.RoleBinding)
+		// If deleted since we started this reconcile, re-run
+		if errors.IsNotFound(err) {
+			return o.run(attempts + 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.RoleBinding = updated
+
+	case ReconcileRecreate:
+		// RoleRef is immutable, so a changed RoleRef requires deleting and recreating the binding.
+		err := o.Client.Delete(result.RoleBinding.Name, &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &existing.UID}})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		created, err := o.Client.Create(result.RoleBinding)
+		if errors.IsAlreadyExists(err) {
+			return o.run(attempts + 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.RoleBinding = created
+
+	case ReconcileNone:
+		// no-op
+
+	default:
+		return nil, fmt.Errorf("invalid operation: %v", result.Operation)
+	}
+
+	return result, nil
+}
+
+// computeReconciledClusterRoleBinding returns the rolebinding that must be created and/or updated to make the
+// existing rolebinding's subjects and role reference match the expected rolebinding
+func computeReconciledClusterRoleBinding(existing, expected *rbac.ClusterRoleBinding, removeExtraSubjects bool, excludeSubjects []rbac.Subject) (*ReconcileClusterRoleBindingResult, error) {
+	result := &ReconcileClusterRoleBindingResult{Operation: ReconcileNone}
+
+	result.Protected = isProtected(existing.Annotations)
+
+	// RoleRef is immutable; if it changed, the binding has to be deleted and recreated wholesale.
+	if !reflect.DeepEqual(existing.RoleRef, expected.RoleRef) {
+		result.RoleBinding = expected
+		result.MissingSubjects = expected.Subjects
+		result.Operation = ReconcileRecreate
+		return result, nil
+	}
+
+	// Start with a copy of the existing object
+	changedObj, err := api.Scheme.DeepCopy(existing)
+	if err != nil {
+		return nil, err
+	}
+	result.RoleBinding = changedObj.(*rbac.ClusterRoleBinding)
+
+	// Merge expected annotations and labels
+	annotations, labels, metaChanged := mergeAnnotationsAndLabels(existing.Annotations, expected.Annotations, existing.Labels, expected.Labels)
+	result.RoleBinding.Annotations = annotations
+	result.RoleBinding.Labels = labels
+	if metaChanged {
+		result.Operation = ReconcileUpdate
+	}
+
+	subjects, missingSubjects, extraSubjects, subjectsChanged := reconcileSubjects(existing.Subjects, expected.Subjects, excludeSubjects, removeExtraSubjects)
+	result.RoleBinding.Subjects = subjects
+	result.MissingSubjects = missingSubjects
+	result.ExtraSubjects = extraSubjects
+	if subjectsChanged {
+		result.Operation = ReconcileUpdate
+	}
+
+	return result, nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciliation
+
+import (
+	"testing"
+
+	"k8s.io/kubernetes/pkg/apis/rbac"
+)
+
+func TestComputeReconciledRoleIgnoresCosmeticRuleDifferences(t *testing.T) {
+	existing := &rbac.ClusterRole{
+		Rules: []rbac.PolicyRule{
+			{Verbs: []string{"list", "get", "get"}, APIGroups: []string{"", ""}, Resources: []string{"Pods"}},
+			{Verbs: []string{"Watch"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+		},
+	}
+	expected := &rbac.ClusterRole{
+		Rules: []rbac.PolicyRule{
+			{Verbs: []string{"GET", "WATCH", "LIST"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+		},
+	}
+
+	result, err := computeReconciledRole(existing, expected, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Operation != ReconcileNone {
+		t.Errorf("got operation %v, want %v (missing=%#v extra=%#v)", result.Operation, ReconcileNone, result.MissingRules, result.ExtraRules)
+	}
+}
+
+func TestComputeReconciledRoleDetectsRealRuleDifferences(t *testing.T) {
+	existing := &rbac.ClusterRole{
+		Rules: []rbac.PolicyRule{
+			{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+		},
+	}
+	expected := &rbac.ClusterRole{
+		Rules: []rbac.PolicyRule{
+			{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+			{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}},
+		},
+	}
+
+	result, err := computeReconciledRole(existing, expected, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Operation != ReconcileUpdate {
+		t.Errorf("got operation %v, want %v", result.Operation, ReconcileUpdate)
+	}
+	if len(result.MissingRules) != 1 {
+		t.Errorf("got %d missing rules, want 1: %#v", len(result.MissingRules), result.MissingRules)
+	}
+}
@@ -0,0 +1,32 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciliation
+
+import "k8s.io/kubernetes/pkg/apis/rbac"
+
+// Recorder lets a caller observe ClusterRole reconciliation results, typically to surface them
+// as Kubernetes Events (e.g. backed by an events.EventRecorder) so operators can see drift
+// between desired bootstrap policy and live cluster state instead of it passing silently.
+type Recorder interface {
+	// RoleReconciled is called after a reconcile that found rules missing from and/or extra on
+	// the existing role, whatever the resulting op (including ReconcileNone), reporting those rules.
+	RoleReconciled(name string, op ReconcileOperation, missing, extra []rbac.PolicyRule)
+	// RoleProtected is called on every reconcile of an existing role that opted out of
+	// reconciliation via the rbac.authorization.k8s.io/autoupdate annotation, whether or not
+	// the reconcile found anything to change.
+	RoleProtected(name string)
+}
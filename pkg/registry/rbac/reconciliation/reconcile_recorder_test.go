@@ -0,0 +1,136 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciliation
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/apis/rbac"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
+)
+
+type recordedEvent struct {
+	protectedName string
+	reconciled    string
+	op            ReconcileOperation
+}
+
+type fakeRecorder struct {
+	events []recordedEvent
+}
+
+func (f *fakeRecorder) RoleReconciled(name string, op ReconcileOperation, missing, extra []rbac.PolicyRule) {
+	f.events = append(f.events, recordedEvent{reconciled: name, op: op})
+}
+
+func (f *fakeRecorder) RoleProtected(name string) {
+	f.events = append(f.events, recordedEvent{protectedName: name})
+}
+
+func TestReconcileClusterRoleOptionsRunNotifiesRecorder(t *testing.T) {
+	recorder := &fakeRecorder{}
+	existing := &rbac.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: "edit"},
+		Rules:      []rbac.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+	}
+	client := fake.NewSimpleClientset(existing)
+
+	options := &ReconcileClusterRoleOptions{
+		Role: &rbac.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "edit"},
+			Rules: []rbac.PolicyRule{
+				{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+				{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}},
+			},
+		},
+		Confirm:  true,
+		Client:   client.Rbac().ClusterRoles(),
+		Recorder: recorder,
+	}
+
+	result, err := options.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Operation != ReconcileUpdate {
+		t.Fatalf("got operation %v, want %v", result.Operation, ReconcileUpdate)
+	}
+	if len(recorder.events) != 1 || recorder.events[0].reconciled != "edit" || recorder.events[0].op != ReconcileUpdate {
+		t.Fatalf("expected one RoleReconciled event for %q, got %#v", "edit", recorder.events)
+	}
+}
+
+func TestReconcileClusterRoleOptionsRunNotifiesRecorderOnExtraRulesWithoutUpdate(t *testing.T) {
+	recorder := &fakeRecorder{}
+	existing := &rbac.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: "edit"},
+		Rules: []rbac.PolicyRule{
+			{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+			{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}},
+		},
+	}
+	client := fake.NewSimpleClientset(existing)
+
+	options := &ReconcileClusterRoleOptions{
+		Role: &rbac.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "edit"},
+			Rules:      []rbac.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+		},
+		// RemoveExtraPermissions is false (the default union mode), so the extra "secrets" rule
+		// is detected but never removed, and Operation stays ReconcileNone.
+		Confirm:  true,
+		Client:   client.Rbac().ClusterRoles(),
+		Recorder: recorder,
+	}
+
+	result, err := options.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Operation != ReconcileNone {
+		t.Fatalf("got operation %v, want %v", result.Operation, ReconcileNone)
+	}
+	if len(recorder.events) != 1 || recorder.events[0].reconciled != "edit" || recorder.events[0].op != ReconcileNone {
+		t.Fatalf("expected one RoleReconciled event reporting extra rules for %q, got %#v", "edit", recorder.events)
+	}
+}
+
+func TestReconcileClusterRoleOptionsRunNotifiesRecorderOnProtected(t *testing.T) {
+	recorder := &fakeRecorder{}
+	existing := &rbac.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "edit",
+			Annotations: map[string]string{rbac.AutoUpdateAnnotationKey: "false"},
+		},
+	}
+	client := fake.NewSimpleClientset(existing)
+
+	options := &ReconcileClusterRoleOptions{
+		Role:     &rbac.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "edit"}},
+		Confirm:  true,
+		Client:   client.Rbac().ClusterRoles(),
+		Recorder: recorder,
+	}
+
+	if _, err := options.Run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(recorder.events) != 1 || recorder.events[0].protectedName != "edit" {
+		t.Fatalf("expected one RoleProtected event for %q, got %#v", "edit", recorder.events)
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciliation
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/rbac"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/typed/rbac/internalversion"
+)
+
+// ReconcileRoleOptions reconciles a namespaced rbac.Role the same way
+// ReconcileClusterRoleOptions reconciles a ClusterRole.
+type ReconcileRoleOptions struct {
+	// Role is the expected role that will be reconciled
+	Role *rbac.Role
+	// Confirm indicates writes should be performed. When false, results are returned as a dry-run.
+	Confirm bool
+	// RemoveExtraPermissions indicates reconciliation should remove extra permissions from an existing role
+	RemoveExtraPermissions bool
+	// Client is used to look up existing roles, and create/update the role when Confirm=true.
+	// It must already be scoped to Role.Namespace.
+	Client internalversion.RoleInterface
+}
+
+type ReconcileRoleResult struct {
+	// Role is the reconciled role from the reconciliation operation.
+	// If the reconcile was performed as a dry-run, or the existing role was protected, the reconciled role is not persisted.
+	Role *rbac.Role
+
+	// MissingRules contains expected rules that were missing from the currently persisted role
+	MissingRules []rbac.PolicyRule
+	// ExtraRules contains extra permissions the currently persisted role had
+	ExtraRules []rbac.PolicyRule
+
+	// Operation is the API operation required to reconcile.
+	// If no reconciliation was needed, it is set to ReconcileNone.
+	// If options.Confirm == false, the reconcile was in dry-run mode, so the operation was not performed.
+	// If result.Protected == true, the role opted out of reconciliation, so the operation was not performed.
+	// Otherwise, the operation was performed.
+	Operation ReconcileOperation
+	// Protected indicates an existing role prevented reconciliation
+	Protected bool
+}
+
+func (o *ReconcileRoleOptions) Run() (*ReconcileRoleResult, error) {
+	start := time.Now()
+	result, err := o.run(0)
+	if err != nil {
+		return result, err
+	}
+	if o.Confirm {
+		// Dry-run reconciles never perform the operation they compute, so don't count them as
+		// if they had.
+		observeReconcile(result.Operation, result.Protected, start)
+	}
+	return result, nil
+}
+
+func (o *ReconcileRoleOptions) run(attempts int) (*ReconcileRoleResult, error) {
+	// This keeps us from retrying forever if a role keeps appearing and disappearing as we reconcile.
+	// Conflict errors on update are handled at a higher level.
+	if attempts > 2 {
+		return nil, fmt.Errorf("exceeded maximum attempts")
+	}
+
+	var result *ReconcileRoleResult
+
+	existing, err := o.Client.Get(o.Role.Name, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		result = &ReconcileRoleResult{
+			Role:         o.Role,
+			MissingRules: o.Role.Rules,
+			Operation:    ReconcileCreate,
+		}
+
+	case err != nil:
+		return nil, err
+
+	default:
+		result, err = computeReconciledNamespaceRole(existing, o.Role, o.RemoveExtraPermissions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// If reconcile-protected, short-circuit
+	if result.Protected {
+		return result, nil
+	}
+	// If we're in dry-run mode, short-circuit
+	if !o.Confirm {
+		return result, nil
+	}
+
+	switch result.Operation {
+	case ReconcileCreate:
+		created, err := o.Client.Create(result.Role)
+		// If created since we started this reconcile, re-run
+		if errors.IsAlreadyExists(err) {
+			return o.run(attempts + 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.Role = created
+
+	case ReconcileUpdate:
+		updated, err := o.Client.Update(result.Role)
+		// If deleted since we started this reconcile, re-run
+		if errors.IsNotFound(err) {
+			return o.run(attempts + 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.Role = updated
+
+	case ReconcileNone:
+		// no-op
+
+	default:
+		return nil, fmt.Errorf("invalid operation: %v", result.Operation)
+	}
+
+	return result, nil
+}
+
+// computeReconciledNamespaceRole returns the role that must be created and/or updated to make the
+// existing role's permissions match the expected role's permissions
+func computeReconciledNamespaceRole(existing, expected *rbac.Role, removeExtraPermissions bool) (*ReconcileRoleResult, error) {
+	result := &ReconcileRoleResult{Operation: ReconcileNone}
+
+	result.Protected = isProtected(existing.Annotations)
+
+	// Start with a copy of the existing object
+	changedObj, err := api.Scheme.DeepCopy(existing)
+	if err != nil {
+		return nil, err
+	}
+	result.Role = changedObj.(*rbac.Role)
+
+	// Merge expected annotations and labels
+	annotations, labels, metaChanged := mergeAnnotationsAndLabels(existing.Annotations, expected.Annotations, existing.Labels, expected.Labels)
+	result.Role.Annotations = annotations
+	result.Role.Labels = labels
+	if metaChanged {
+		result.Operation = ReconcileUpdate
+	}
+
+	rules, missingRules, extraRules, rulesChanged := reconcilePolicyRules(existing.Rules, expected.Rules, removeExtraPermissions)
+	result.Role.Rules = rules
+	result.MissingRules = missingRules
+	result.ExtraRules = extraRules
+	if rulesChanged {
+		result.Operation = ReconcileUpdate
+	}
+
+	return result, nil
+}